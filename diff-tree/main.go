@@ -8,21 +8,29 @@ import (
 	"io"
 	"log"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/helper/polyfill"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/storage/memory"
-	"github.com/go-git/go-git/v5/utils/merkletrie"
 	"github.com/stretchr/testify/assert"
 )
 
 var (
 	ErrRootCommit = fmt.Errorf("error because this is root commit")
+
+	// ErrMergeCommit is returned by diffTree for a commit with more than one
+	// parent unless the caller opts into DiffTreeOptions.CombinedDiff. A
+	// plain two-way diff against the first parent would silently hide
+	// whatever the merge actually resolved, so we refuse instead of guessing.
+	ErrMergeCommit = fmt.Errorf("error because this is merge commit")
 )
 
 // main will implement git diff-tree
@@ -58,29 +66,203 @@ func main() {
 	t := Test()
 
 	// you can compare here: https://github.com/yusufsyaifudin/benthos-sample/commit/fe2c1dad736aeb8ffa996d777e4b6c7dc14e21d6
-	cidfe2c1da, err := diffTree(repo, "fe2c1dad736aeb8ffa996d777e4b6c7dc14e21d6")
-	assert.EqualValues(t, []string{"README.md", "config/kafka/jaas.conf", "docker-compose-kafka.yaml"}, cidfe2c1da)
+	resultFe2c1da, err := diffTree(ctx, repo, "fe2c1dad736aeb8ffa996d777e4b6c7dc14e21d6", DiffTreeOptions{})
+	assert.EqualValues(t, []string{"README.md", "config/kafka/jaas.conf", "docker-compose-kafka.yaml"}, paths(resultFe2c1da.Files))
+	assert.NoError(t, err)
+
+	fmt.Println()
+
+	// same commit, but this time asking for the equivalent of `git show`:
+	// a unified diff patch plus a `--stat` summary.
+	patchFe2c1da, err := diffTree(ctx, repo, "fe2c1dad736aeb8ffa996d777e4b6c7dc14e21d6", DiffTreeOptions{Patch: true, Stat: true})
 	assert.NoError(t, err)
+	fmt.Println(patchFe2c1da.Stat)
+	fmt.Println(patchFe2c1da.Patch)
 
 	fmt.Println()
 
 	// you can compare here: https://github.com/yusufsyaifudin/benthos-sample/commit/685438b58b9d75094fc15f97e29a416e6f9222a0
-	cid685438b, err := diffTree(repo, "685438b58b9d75094fc15f97e29a416e6f9222a0")
-	assert.EqualValues(t, []string{".gitignore", "golang/Makefile"}, cid685438b)
+	cid685438b, err := diffTree(ctx, repo, "685438b58b9d75094fc15f97e29a416e6f9222a0", DiffTreeOptions{})
+	assert.EqualValues(t, []string{".gitignore", "golang/Makefile"}, paths(cid685438b.Files))
 	assert.NoError(t, err)
 
 	fmt.Println()
 
 	// you can compare here: https://github.com/yusufsyaifudin/benthos-sample/commit/95e2b7dfabc5f43161a979a7e44dc0005dcfd467
-	cid95e2b7d, err := diffTree(repo, "95e2b7dfabc5f43161a979a7e44dc0005dcfd467")
-	assert.Empty(t, cid95e2b7d)
+	cid95e2b7d, err := diffTree(ctx, repo, "95e2b7dfabc5f43161a979a7e44dc0005dcfd467", DiffTreeOptions{})
+	assert.Empty(t, cid95e2b7d.Files)
 	assert.Error(t, err)
 	assert.ErrorIs(t, err, ErrRootCommit)
 
+	fmt.Println()
+
+	// Plain DiffTree (and DiffTreeWithOptions without rename detection) would
+	// report a rename as a Delete of the old path plus an Insert of the new
+	// one. testRenameDetection proves that diffTree instead collapses that
+	// pair into a single Rename record once -M<threshold> is satisfied.
+	testRenameDetection(ctx, t)
+
+	// A still-present, untouched file with the same content as a newly
+	// inserted one is reported as a Copy, not a plain Insert.
+	testCopyDetection(ctx, t)
+
+	// Two files inserted together with identical content have no prior
+	// unchanged source to have been copied from, so both must stay Inserts.
+	testCopyDetectionIgnoresSimultaneousInserts(ctx, t)
+
+	// A brand-new file's hunk header must use a 0 start on the old side
+	// (and a full deletion a 0 start on the new side), or the patch won't
+	// apply with `git apply`.
+	testPatchHunkHeaderZeroCounts(ctx, t)
+
+	// Merge commits need an explicit opt-in (CombinedDiff) or diffTree
+	// refuses them outright rather than silently diffing against the first
+	// parent only.
+	testCombinedDiff(ctx, t)
+
+	fmt.Println()
+
+	// logDiffTree reproduces `git log -- README.md`: walk history from HEAD,
+	// only surfacing commits that touched README.md.
+	testLogDiffTreePathFilter(ctx, t, repo)
+
+	// A merge commit must still be surfaced, not dropped, diffed against
+	// its first parent only and tagged IsMerge.
+	testLogDiffTreeMergeCommit(ctx, t)
+
+	fmt.Println()
+
+	// diffWorktree reproduces `git status`: uncommitted changes, split into
+	// staged vs unstaged, using the same FileChange shape as diffTree.
+	testDiffWorktreeStaged(t)
+
+	// An untracked file must only ever show up on the unstaged side.
+	testDiffWorktreeUntracked(t)
+
 	return
 
 }
 
+// ChangeAction enumerates the kind of change a FileChange record carries.
+// It mirrors the actions `git diff-tree` itself can report, which is a
+// superset of the three merkletrie knows about (Insert/Delete/Modify).
+type ChangeAction string
+
+const (
+	ActionInsert ChangeAction = "Insert"
+	ActionDelete ChangeAction = "Delete"
+	ActionModify ChangeAction = "Modify"
+	ActionRename ChangeAction = "Rename"
+	ActionCopy   ChangeAction = "Copy"
+)
+
+// FileChange describes a single path changed between two trees. diffTree's
+// two-way diff sets OldPath for Delete/Modify/Rename/Copy and NewPath for
+// Insert/Modify/Rename/Copy; combined-diff entries and diffWorktree's records
+// only ever set NewPath, whatever their Action.
+// Score is the similarity percentage (0-100) and is only meaningful for
+// Rename and Copy, mirroring the number `git diff-tree -M`/`-C` prints next
+// to a rename/copy pair.
+type FileChange struct {
+	Action  ChangeAction
+	OldPath string
+	NewPath string
+	Score   uint8
+
+	// Markers holds one action letter per parent ('A'dd/'M'odify/'D'elete),
+	// e.g. "MM" or "AM", in the same order as the merge commit's parents.
+	// It is only set for combined-diff entries (DiffTreeOptions.CombinedDiff).
+	Markers string
+}
+
+// path returns whichever of NewPath/OldPath is set, which is what the
+// name-only mode of `git diff-tree` prints for a given change.
+func (f FileChange) path() string {
+	if f.NewPath != "" {
+		return f.NewPath
+	}
+
+	return f.OldPath
+}
+
+// paths extracts the name-only view out of a slice of FileChange, i.e. what
+// `git diff-tree --name-only` prints. Kept as a small helper so the existing
+// fixture assertions don't need to know about the richer FileChange shape.
+func paths(changes []FileChange) []string {
+	out := make([]string, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, c.path())
+	}
+
+	return out
+}
+
+// defaultRenameSimilarity is used whenever DiffTreeOptions.RenameSimilarity
+// is left at its zero value. It matches git's own default of 50%.
+const defaultRenameSimilarity = 50
+
+// defaultPatchContextLines is used whenever DiffTreeOptions.PatchContextLines
+// is left at its zero value. It matches git's own default of 3.
+const defaultPatchContextLines = 3
+
+// DiffTreeOptions tunes how diffTree turns the raw merkletrie changes into
+// FileChange records, and optionally into a textual patch.
+type DiffTreeOptions struct {
+	// RenameSimilarity is the minimum percentage (0-100) of shared content
+	// two files must have for a Delete+Insert pair to be reported as a
+	// single Rename instead. It is the equivalent of git's `-M<n>`
+	// threshold. Zero selects defaultRenameSimilarity. Copies are reported
+	// only for exact content matches, whatever this is set to.
+	RenameSimilarity uint8
+
+	// Patch, when true, additionally renders a unified diff of the commit,
+	// equivalent to `git diff-tree -p` / `git show`. The name-only
+	// FileChange records are always computed regardless of this flag.
+	Patch bool
+
+	// PatchContextLines is the number of unchanged lines kept around each
+	// hunk when Patch is true. Zero selects defaultPatchContextLines.
+	PatchContextLines int
+
+	// Stat, when true, additionally renders a `--stat` summary (files
+	// changed, insertions, deletions). It does not require Patch.
+	Stat bool
+
+	// CombinedDiff opts into `git diff-tree -c`/`--cc` semantics for merge
+	// commits: every parent is diffed against the selected tree and only
+	// paths that changed versus *every* parent are reported, each tagged
+	// with a per-parent marker (see FileChange.Markers). Without it, a
+	// merge commit makes diffTree return ErrMergeCommit instead of quietly
+	// diffing against the first parent. For a merge commit diffed this way,
+	// Patch and Stat are not rendered and stay empty.
+	CombinedDiff bool
+}
+
+func (o DiffTreeOptions) similarityThreshold() uint8 {
+	if o.RenameSimilarity == 0 {
+		return defaultRenameSimilarity
+	}
+
+	return o.RenameSimilarity
+}
+
+func (o DiffTreeOptions) contextLines() int {
+	if o.PatchContextLines <= 0 {
+		return defaultPatchContextLines
+	}
+
+	return o.PatchContextLines
+}
+
+// DiffTreeResult is everything diffTree can report about a commit: the
+// name-only change records, and, when requested via DiffTreeOptions, a
+// unified diff patch and/or a --stat summary.
+type DiffTreeResult struct {
+	Files []FileChange
+	Patch string
+	Stat  string
+}
+
 // diffTree is helper function to get the changed file in specific commit. The command is as follows:
 // git diff-tree --no-commit-id --name-only -r {selected-commit-id} {parent-of-selected-commit-id}
 // But, we can omit the {parent-of-selected-commit-id} and the simplified version is:
@@ -90,8 +272,8 @@ func main() {
 // This is expected behavior on git diff-tree. https://stackoverflow.com/a/424142
 //
 // You must pass long version of SHA-1 hash string to get the actual value. Otherwise, it will be Fatal error.
-func diffTree(repo *git.Repository, commitID string) (files []string, err error) {
-	files = make([]string, 0)
+func diffTree(ctx context.Context, repo *git.Repository, commitID string, opts DiffTreeOptions) (result DiffTreeResult, err error) {
+	result.Files = make([]FileChange, 0)
 
 	selectedCommit, err := repo.CommitObject(plumbing.NewHash(commitID))
 	if err != nil {
@@ -105,8 +287,17 @@ func diffTree(repo *git.Repository, commitID string) (files []string, err error)
 		return
 	}
 
-	parentCommit, err := selectedCommit.Parents().Next()
-	if errors.Is(err, io.EOF) {
+	var parents []*object.Commit
+	err = selectedCommit.Parents().ForEach(func(c *object.Commit) error {
+		parents = append(parents, c)
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("error parents iter object: %w", err)
+		return
+	}
+
+	if len(parents) == 0 {
 		err = fmt.Errorf("%w: commit id %s", ErrRootCommit, commitID)
 
 		// expected behavior of git diff-tree
@@ -115,66 +306,1195 @@ func diffTree(repo *git.Repository, commitID string) (files []string, err error)
 		return
 	}
 
-	if err != nil {
-		err = fmt.Errorf("error parents iter object: %w", err)
+	if len(parents) > 1 {
+		if !opts.CombinedDiff {
+			err = fmt.Errorf("%w: commit id %s has %d parents", ErrMergeCommit, commitID, len(parents))
+
+			fmt.Printf("skipping merge commit %s (%d parents); set DiffTreeOptions.CombinedDiff to diff it\n", selectedCommit.Hash.String()[:7], len(parents))
+			fmt.Println(strings.Repeat("-", 30))
+			return
+		}
+
+		result.Files, err = combinedDiff(parents, selectedCommitTree)
+		if err != nil {
+			err = fmt.Errorf("error combined diff of merge commit %s: %w", commitID, err)
+			return
+		}
+
+		fmt.Printf("combined diff of merge commit %s (%d parents)\n", selectedCommit.Hash.String()[:7], len(parents))
+		fmt.Println(strings.Repeat("-", 30))
+
+		for _, file := range result.Files {
+			fmt.Println(file.Markers, file.path())
+		}
+
 		return
 	}
 
+	parentCommit := parents[0]
+
 	parentCommitTree, err := parentCommit.Tree()
 	if err != nil {
 		err = fmt.Errorf("error get parent commit of commit %s: %w", commitID, err)
 		return
 	}
 
-	changes, err := object.DiffTree(parentCommitTree, selectedCommitTree)
+	fmt.Printf("diff between %s vs %s\n", parentCommit.Hash.String()[:7], selectedCommit.Hash.String()[:7])
+	fmt.Println(strings.Repeat("-", 30))
+
+	result.Files, err = diffTreePair(repo, parentCommitTree, selectedCommitTree, opts)
 	if err != nil {
-		err = fmt.Errorf("error diff tree: %w", err)
+		err = fmt.Errorf("error diffing commit %s against parent %s: %w", commitID, parentCommit.Hash, err)
 		return
 	}
 
-	fmt.Printf("diff between %s vs %s\n", parentCommit.Hash.String()[:7], selectedCommit.Hash.String()[:7])
-	fmt.Println(strings.Repeat("-", 30))
+	for _, file := range result.Files {
+		insideDir := "[x]"
+		if filepath.Dir(file.path()) != "." { // If the path is empty, Dir returns ".".
+			insideDir = "[v]"
+		}
+
+		if file.Action == ActionRename || file.Action == ActionCopy {
+			fmt.Println(insideDir, file.OldPath, "->", file.NewPath, file.Action, fmt.Sprintf("%d%%", file.Score))
+			continue
+		}
+
+		fmt.Println(insideDir, file.path(), file.Action)
+	}
+
+	if !opts.Patch && !opts.Stat {
+		return
+	}
+
+	// parentCommit.PatchContext honors ctx cancellation itself, so a large
+	// diff can't hang past the caller's timeout.
+	patch, err := parentCommit.PatchContext(ctx, selectedCommit)
+	if err != nil {
+		err = fmt.Errorf("error building patch between %s vs %s: %w", parentCommit.Hash, selectedCommit.Hash, err)
+		return
+	}
 
-	// Below logic is extracted from library logic:
-	// * To get file action: https://github.com/go-git/go-git/blob/v5.4.2/plumbing/object/change.go#L23-L40
-	// * To get file name: https://github.com/go-git/go-git/blob/v5.4.2/plumbing/object/change.go#L98-L104
-	// This actually what https://github.com/go-git/go-git/blob/v5.4.2/plumbing/object/change.go#L98-L104 and
-	// https://github.com/go-git/go-git/blob/v5.4.2/plumbing/object/change.go#L75-L82 are doing.
-	// Why we don't use library Action() function is because we need to get file name.
-	// And as we see, the c.name() on L98-L104 is need by default using c.To, unless c.From is not empty.
+	if opts.Patch {
+		result.Patch, err = renderPatch(patch, opts.contextLines())
+		if err != nil {
+			err = fmt.Errorf("error rendering patch between %s vs %s: %w", parentCommit.Hash, selectedCommit.Hash, err)
+			return
+		}
+	}
+
+	if opts.Stat {
+		result.Stat = renderStat(patch)
+	}
+
+	return
+}
+
+// diffTreePair is the rename/copy-aware tree diff shared by diffTree's
+// normal single-parent path and logDiffTree's first-parent fallback for
+// merge commits: it runs object.DiffTree between the two trees and hands the
+// raw changes to collapseRenames.
+func diffTreePair(repo *git.Repository, parentTree, selectedTree *object.Tree, opts DiffTreeOptions) ([]FileChange, error) {
+	changes, err := object.DiffTree(parentTree, selectedTree)
+	if err != nil {
+		return nil, fmt.Errorf("error diff tree: %w", err)
+	}
+
+	return collapseRenames(repo, parentTree, selectedTree, changes, opts)
+}
+
+// collapseRenames turns the raw Insert/Delete/Modify changes `object.DiffTree`
+// reports into FileChange records. It pairs up deletes and inserts whose
+// blob content is similar enough to count as a Rename instead of two
+// unrelated changes, and then, for any insert that's still left over, checks
+// whether its content exactly matches some other, untouched path that
+// already existed in parentTree, reporting that as a Copy. This is what git
+// itself does once `-M`/`-C` is passed, and what go-git's own
+// object.DiffTreeWithOptions/DefaultDiffTreeOptions do under the hood; it is
+// reimplemented here, in the same spirit as the rest of this file, to show
+// how the pairing actually works.
+func collapseRenames(repo *git.Repository, parentTree, selectedTree *object.Tree, changes object.Changes, opts DiffTreeOptions) ([]FileChange, error) {
 	var empty object.ChangeEntry
+
+	var deletes, inserts []*object.Change
+	var result []FileChange
+
 	for _, c := range changes {
-		if c.From == empty && c.To == empty {
-			err = fmt.Errorf("malformed change: empty from and to")
-			return
+		switch {
+		case c.From == empty && c.To == empty:
+			return nil, fmt.Errorf("malformed change: empty from and to")
+		case c.From == empty:
+			inserts = append(inserts, c)
+		case c.To == empty:
+			deletes = append(deletes, c)
+		default:
+			result = append(result, FileChange{
+				Action:  ActionModify,
+				OldPath: c.From.Name,
+				NewPath: c.To.Name,
+			})
 		}
+	}
+
+	threshold := opts.similarityThreshold()
+	matchedInserts := make(map[int]bool, len(inserts))
 
-		var action = merkletrie.Modify
-		if c.From == empty {
-			action = merkletrie.Insert
+	for _, del := range deletes {
+		bestScore := uint8(0)
+		bestIdx := -1
+
+		for i, ins := range inserts {
+			if matchedInserts[i] {
+				continue
+			}
+
+			score, err := blobSimilarity(repo, del.From.TreeEntry.Hash, ins.To.TreeEntry.Hash)
+			if err != nil {
+				return nil, err
+			}
+
+			if score > bestScore {
+				bestScore = score
+				bestIdx = i
+			}
 		}
 
-		if c.To == empty {
-			action = merkletrie.Delete
+		if bestIdx >= 0 && bestScore >= threshold {
+			matchedInserts[bestIdx] = true
+			result = append(result, FileChange{
+				Action:  ActionRename,
+				OldPath: del.From.Name,
+				NewPath: inserts[bestIdx].To.Name,
+				Score:   bestScore,
+			})
+			continue
 		}
 
-		file := c.To
-		if c.From != empty {
-			file = c.From
+		result = append(result, FileChange{
+			Action:  ActionDelete,
+			OldPath: del.From.Name,
+		})
+	}
+
+	for i, ins := range inserts {
+		if matchedInserts[i] {
+			continue
 		}
 
-		insideDir := "[x]"
-		if filepath.Dir(file.Name) != "." { // If the path is empty, Dir returns ".".
-			insideDir = "[v]"
+		sourcePath, err := findCopySource(parentTree, selectedTree, ins.To.Name, ins.To.TreeEntry.Hash)
+		if err != nil {
+			return nil, err
 		}
 
-		files = append(files, file.Name)
-		fmt.Println(insideDir, file.Name, action)
+		if sourcePath != "" {
+			matchedInserts[i] = true
+			result = append(result, FileChange{
+				Action:  ActionCopy,
+				OldPath: sourcePath,
+				NewPath: ins.To.Name,
+				Score:   100,
+			})
+		}
 	}
 
-	return
+	for i, ins := range inserts {
+		if matchedInserts[i] {
+			continue
+		}
+
+		result = append(result, FileChange{
+			Action:  ActionInsert,
+			NewPath: ins.To.Name,
+		})
+	}
+
+	// object.DiffTree does not guarantee ordering once we split/regroup the
+	// changes above, so sort for deterministic, diff-tree-like output.
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].path() < result[j].path()
+	})
+
+	return result, nil
+}
+
+// findCopySource looks for a path, other than insertedPath, that already
+// existed in parentTree with a blob matching blobHash, and is still present
+// at that same path and hash in selectedTree. This is the same thing git's
+// default (i.e. without --find-copies-harder) `-C` detection does: a copy is
+// only recognized when its source content was already there beforehand and
+// is left untouched by the commit, which rules out two files inserted
+// together with identical content being mistaken for copies of each other.
+// Returns "" if no such path exists.
+func findCopySource(parentTree, selectedTree *object.Tree, insertedPath string, blobHash plumbing.Hash) (string, error) {
+	walker := object.NewTreeWalker(parentTree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("error walking tree: %w", err)
+		}
+
+		if name == insertedPath || !entry.Mode.IsRegular() || entry.Hash != blobHash {
+			continue
+		}
+
+		unchanged, err := selectedTree.FindEntry(name)
+		if err != nil || unchanged.Hash != blobHash {
+			continue
+		}
+
+		return name, nil
+	}
+}
+
+// combinedDiff implements `git diff-tree -c`/`--cc` semantics: every parent
+// is diffed against the merge commit's tree, and only paths that changed
+// versus *every* parent are reported, each tagged with a per-parent marker
+// ('A'dd/'M'odify/'D'elete) in parent order.
+func combinedDiff(parents []*object.Commit, selectedTree *object.Tree) ([]FileChange, error) {
+	var empty object.ChangeEntry
+
+	perParentMarkers := make([]map[string]byte, len(parents))
+
+	for i, parent := range parents {
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("error get tree of parent %s: %w", parent.Hash, err)
+		}
+
+		changes, err := object.DiffTree(parentTree, selectedTree)
+		if err != nil {
+			return nil, fmt.Errorf("error diff tree against parent %s: %w", parent.Hash, err)
+		}
+
+		markers := make(map[string]byte, len(changes))
+		for _, c := range changes {
+			switch {
+			case c.From == empty:
+				markers[c.To.Name] = 'A'
+			case c.To == empty:
+				markers[c.From.Name] = 'D'
+			default:
+				markers[c.To.Name] = 'M'
+			}
+		}
+
+		perParentMarkers[i] = markers
+	}
+
+	var result []FileChange
+
+	for path := range perParentMarkers[0] {
+		markers := make([]byte, len(parents))
+		markers[0] = perParentMarkers[0][path]
+
+		changedVsAll := true
+		for i := 1; i < len(perParentMarkers); i++ {
+			marker, ok := perParentMarkers[i][path]
+			if !ok {
+				changedVsAll = false
+				break
+			}
+
+			markers[i] = marker
+		}
+
+		if !changedVsAll {
+			continue
+		}
+
+		result = append(result, FileChange{
+			Action:  ActionModify,
+			NewPath: path,
+			Markers: string(markers),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].path() < result[j].path()
+	})
+
+	return result, nil
+}
+
+// blobSimilarity returns a 0-100 score describing how similar two blobs are,
+// following the same line-based idea git's own rename detection uses: the
+// number of lines the two blobs share, as a percentage of the line count of
+// the longer one.
+func blobSimilarity(repo *git.Repository, from, to plumbing.Hash) (uint8, error) {
+	if from == to {
+		return 100, nil
+	}
+
+	fromLines, err := blobLines(repo, from)
+	if err != nil {
+		return 0, err
+	}
+
+	toLines, err := blobLines(repo, to)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(fromLines) == 0 && len(toLines) == 0 {
+		return 100, nil
+	}
+
+	available := make(map[string]int, len(fromLines))
+	for _, line := range fromLines {
+		available[line]++
+	}
+
+	shared := 0
+	for _, line := range toLines {
+		if available[line] > 0 {
+			available[line]--
+			shared++
+		}
+	}
+
+	total := len(fromLines)
+	if len(toLines) > total {
+		total = len(toLines)
+	}
+
+	if total == 0 {
+		return 100, nil
+	}
+
+	return uint8(shared * 100 / total), nil
+}
+
+// blobLines loads a blob by hash and splits it into lines for similarity
+// comparison.
+func blobLines(repo *git.Repository, hash plumbing.Hash) ([]string, error) {
+	blob, err := object.GetBlob(repo.Storer, hash)
+	if err != nil {
+		return nil, fmt.Errorf("error get blob %s: %w", hash, err)
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("error read blob %s: %w", hash, err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error read blob content %s: %w", hash, err)
+	}
+
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	return strings.Split(string(content), "\n"), nil
+}
+
+// WorktreeChange is a FileChange observed by diffWorktree, tagged with
+// whether it belongs to the index (staged) or the working copy (unstaged),
+// so downstream code can reuse the same FileChange shape diffTree produces
+// instead of branching on "commit diff" vs "worktree diff".
+type WorktreeChange struct {
+	FileChange
+	Staged bool
+}
+
+// Describe renders a WorktreeChange the way `git status` prose would, e.g.
+// "Added (staged)" or "Modified (unstaged)".
+func (w WorktreeChange) Describe() string {
+	verbs := map[ChangeAction]string{
+		ActionInsert: "Added",
+		ActionDelete: "Deleted",
+		ActionModify: "Modified",
+		ActionRename: "Renamed",
+		ActionCopy:   "Copied",
+	}
+
+	verb, ok := verbs[w.Action]
+	if !ok {
+		verb = string(w.Action)
+	}
+
+	area := "unstaged"
+	if w.Staged {
+		area = "staged"
+	}
+
+	return fmt.Sprintf("%s (%s)", verb, area)
+}
+
+// statusAction maps a git.StatusCode (as reported by Worktree.Status) onto
+// our own ChangeAction, the same vocabulary diffTree uses.
+func statusAction(code git.StatusCode) (ChangeAction, bool) {
+	switch code {
+	case git.Added, git.Untracked:
+		return ActionInsert, true
+	case git.Deleted:
+		return ActionDelete, true
+	case git.Modified:
+		return ActionModify, true
+	case git.Renamed:
+		return ActionRename, true
+	case git.Copied:
+		return ActionCopy, true
+	default:
+		return "", false
+	}
+}
+
+// diffWorktree reports how the index and working copy differ from HEAD,
+// i.e. what `git status` shows, using the same FileChange shape diffTree
+// uses. Staged (index vs HEAD) and unstaged (worktree vs index) changes for
+// the same path are reported as separate WorktreeChange records so callers
+// can tell them apart.
+//
+// fs is optional: pass nil to use the filesystem the repository was
+// opened/cloned with, or a specific billy.Filesystem (e.g. another memfs,
+// or an on-disk one via osfs) to diff against instead.
+func diffWorktree(repo *git.Repository, fs billy.Filesystem) ([]WorktreeChange, error) {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("error get worktree: %w", err)
+	}
+
+	if fs != nil {
+		worktree.Filesystem = fs
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, fmt.Errorf("error get worktree status: %w", err)
+	}
+
+	var changes []WorktreeChange
+
+	for path, fileStatus := range status {
+		// An untracked file is never staged: go-git reports both Staging
+		// and Worktree as Untracked for it, but that must only surface as
+		// an unstaged change, or it would double-report as both
+		// "Added (staged)" and "Added (unstaged)".
+		if fileStatus.Staging != git.Untracked {
+			if action, ok := statusAction(fileStatus.Staging); ok {
+				changes = append(changes, WorktreeChange{
+					FileChange: FileChange{Action: action, NewPath: path},
+					Staged:     true,
+				})
+			}
+		}
+
+		if action, ok := statusAction(fileStatus.Worktree); ok {
+			changes = append(changes, WorktreeChange{
+				FileChange: FileChange{Action: action, NewPath: path},
+				Staged:     false,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].path() != changes[j].path() {
+			return changes[i].path() < changes[j].path()
+		}
+
+		return changes[i].Staged && !changes[j].Staged
+	})
+
+	return changes, nil
+}
+
+// testDiffWorktreeStaged builds a throwaway in-memory repository, stages a
+// new file without committing it, and asserts diffWorktree reports it as a
+// staged Insert.
+func testDiffWorktreeStaged(t *T) {
+	st := memory.NewStorage()
+	wt := memfs.New()
+
+	repo, err := git.Init(st, wt)
+	assert.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	author := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+
+	base, err := wt.Create("base.txt")
+	assert.NoError(t, err)
+	_, err = base.Write([]byte("base\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, base.Close())
+
+	_, err = worktree.Add("base.txt")
+	assert.NoError(t, err)
+
+	_, err = worktree.Commit("base", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	newFile, err := wt.Create("new-file.txt")
+	assert.NoError(t, err)
+	_, err = newFile.Write([]byte("hello\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, newFile.Close())
+
+	_, err = worktree.Add("new-file.txt")
+	assert.NoError(t, err)
+
+	changes, err := diffWorktree(repo, nil)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	if len(changes) == 1 {
+		assert.Equal(t, "new-file.txt", changes[0].NewPath)
+		assert.True(t, changes[0].Staged)
+		assert.Equal(t, "Added (staged)", changes[0].Describe())
+	}
+}
+
+// testDiffWorktreeUntracked builds a throwaway in-memory repository, leaves
+// a new file untracked (never `git add`ed), and asserts diffWorktree reports
+// it exactly once, as an unstaged Insert, rather than as both a staged and
+// an unstaged one.
+func testDiffWorktreeUntracked(t *T) {
+	st := memory.NewStorage()
+	wt := memfs.New()
+
+	repo, err := git.Init(st, wt)
+	assert.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	author := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+
+	base, err := wt.Create("base.txt")
+	assert.NoError(t, err)
+	_, err = base.Write([]byte("base\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, base.Close())
+
+	_, err = worktree.Add("base.txt")
+	assert.NoError(t, err)
+
+	_, err = worktree.Commit("base", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	untracked, err := wt.Create("untracked.txt")
+	assert.NoError(t, err)
+	_, err = untracked.Write([]byte("hello\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, untracked.Close())
+
+	changes, err := diffWorktree(repo, nil)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	if len(changes) == 1 {
+		assert.Equal(t, "untracked.txt", changes[0].NewPath)
+		assert.False(t, changes[0].Staged)
+		assert.Equal(t, "Added (unstaged)", changes[0].Describe())
+	}
+}
+
+// CommitChanges is one record streamed by logDiffTree: the commit that was
+// walked, plus the same FileChange records diffTree would give for it
+// against its first parent.
+type CommitChanges struct {
+	CommitHash string
+	Author     string
+	Time       time.Time
+	Files      []FileChange
+
+	// IsRoot is true for a repository's root commit, which diffTree can't
+	// diff (ErrRootCommit). It is still surfaced here, with an empty Files,
+	// instead of being dropped from the stream.
+	IsRoot bool
+
+	// IsMerge is true for a merge commit. Its Files are computed against the
+	// commit's first parent only (like `git log` does by default), unless
+	// LogOptions.DiffTreeOptions.CombinedDiff is set, in which case they are
+	// diffTree's combined diff against every parent.
+	IsMerge bool
+}
+
+// LogOptions configures logDiffTree.
+type LogOptions struct {
+	// From is the commit hash to start walking from. HEAD is used when empty.
+	From string
+
+	// PathFilter, when set, limits the walk to commits that touched at
+	// least one path it returns true for, i.e. the equivalent of
+	// `git log -- <path>` / `git log -- <regexp>`.
+	PathFilter func(string) bool
+
+	// All seeds the walk from every ref under refs/ plus HEAD, like
+	// `git log --all`, instead of just From.
+	All bool
+
+	// DiffTreeOptions tunes how each commit's changes are computed, e.g.
+	// to enable CombinedDiff for merge commits encountered along the way.
+	DiffTreeOptions DiffTreeOptions
+}
+
+// logDiffTree walks commit history the way `git log --name-only` does,
+// streaming one CommitChanges per visited commit on the returned channel.
+// The channel is closed once the walk finishes (or ctx is done); the caller
+// must drain it to avoid leaking the walking goroutine.
+func logDiffTree(ctx context.Context, repo *git.Repository, opts LogOptions) (<-chan CommitChanges, error) {
+	logOptions := &git.LogOptions{
+		All: opts.All,
+	}
+
+	if opts.From != "" {
+		logOptions.From = plumbing.NewHash(opts.From)
+	}
+
+	if opts.PathFilter != nil {
+		logOptions.PathFilter = opts.PathFilter
+	}
+
+	commitIter, err := repo.Log(logOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error log: %w", err)
+	}
+
+	out := make(chan CommitChanges)
+
+	go func() {
+		defer close(out)
+		defer commitIter.Close()
+
+		err := commitIter.ForEach(func(c *object.Commit) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+
+			diffResult, derr := diffTree(ctx, repo, c.Hash.String(), opts.DiffTreeOptions)
+			isMerge := c.NumParents() > 1
+
+			switch {
+			case errors.Is(derr, ErrRootCommit):
+				// expected: the root commit has nothing to diff against,
+				// but it still belongs in the history stream.
+			case errors.Is(derr, ErrMergeCommit):
+				// caller didn't opt into CombinedDiff, but the merge commit
+				// still belongs in the history stream: diff it against its
+				// first parent only, the same as `git log` does by default.
+				isMerge = true
+
+				firstParent, perr := c.Parent(0)
+				if perr != nil {
+					return fmt.Errorf("error first parent of merge commit %s: %w", c.Hash, perr)
+				}
+
+				firstParentTree, perr := firstParent.Tree()
+				if perr != nil {
+					return fmt.Errorf("error tree of first parent of merge commit %s: %w", c.Hash, perr)
+				}
+
+				selectedTree, perr := c.Tree()
+				if perr != nil {
+					return fmt.Errorf("error tree of merge commit %s: %w", c.Hash, perr)
+				}
+
+				diffResult.Files, derr = diffTreePair(repo, firstParentTree, selectedTree, opts.DiffTreeOptions)
+				if derr != nil {
+					return fmt.Errorf("error diffing merge commit %s against first parent: %w", c.Hash, derr)
+				}
+			case derr != nil:
+				return derr
+			}
+
+			select {
+			case out <- CommitChanges{
+				CommitHash: c.Hash.String(),
+				Author:     c.Author.Name,
+				Time:       c.Author.When,
+				Files:      diffResult.Files,
+				IsRoot:     errors.Is(derr, ErrRootCommit),
+				IsMerge:    isMerge,
+			}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			return nil
+		})
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			fmt.Printf("error walking log: %s\n", err)
+		}
+	}()
+
+	return out, nil
+}
+
+// testLogDiffTreePathFilter walks repo's history filtered to README.md and
+// asserts it includes the commit we already know touches that path.
+func testLogDiffTreePathFilter(ctx context.Context, t *T, repo *git.Repository) {
+	head, err := repo.Head()
+	assert.NoError(t, err)
+
+	changesCh, err := logDiffTree(ctx, repo, LogOptions{
+		From: head.Hash().String(),
+		PathFilter: func(path string) bool {
+			return path == "README.md"
+		},
+	})
+	assert.NoError(t, err)
+
+	var touched []string
+	for cc := range changesCh {
+		touched = append(touched, cc.CommitHash)
+	}
+
+	assert.NotEmpty(t, touched)
+	assert.Contains(t, touched, "fe2c1dad736aeb8ffa996d777e4b6c7dc14e21d6")
+}
+
+// testLogDiffTreeMergeCommit builds a throwaway in-memory repository with a
+// merge commit and asserts logDiffTree still surfaces it, tagged IsMerge,
+// with Files computed against its first parent only, instead of dropping it
+// from the stream the way a bare ErrMergeCommit would.
+func testLogDiffTreeMergeCommit(ctx context.Context, t *T) {
+	st := memory.NewStorage()
+	wt := memfs.New()
+
+	repo, err := git.Init(st, wt)
+	assert.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	author := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+
+	write := func(path, content string) {
+		f, err := wt.Create(path)
+		assert.NoError(t, err)
+
+		_, err = f.Write([]byte(content))
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	write("shared.txt", "base\n")
+	_, err = worktree.Add("shared.txt")
+	assert.NoError(t, err)
+	baseHash, err := worktree.Commit("base", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	assert.NoError(t, worktree.Checkout(&git.CheckoutOptions{Hash: baseHash, Branch: plumbing.ReferenceName("refs/heads/first-parent"), Create: true}))
+	write("from-first-parent.txt", "added on the first parent\n")
+	_, err = worktree.Add("from-first-parent.txt")
+	assert.NoError(t, err)
+	firstParentHash, err := worktree.Commit("change on first parent", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	assert.NoError(t, worktree.Checkout(&git.CheckoutOptions{Hash: baseHash, Branch: plumbing.ReferenceName("refs/heads/other-parent"), Create: true}))
+	write("from-other-parent.txt", "added on the other parent\n")
+	_, err = worktree.Add("from-other-parent.txt")
+	assert.NoError(t, err)
+	otherParentHash, err := worktree.Commit("change on other parent", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	assert.NoError(t, worktree.Checkout(&git.CheckoutOptions{Hash: firstParentHash}))
+	write("merge-result.txt", "resolved during the merge\n")
+	_, err = worktree.Add("merge-result.txt")
+	assert.NoError(t, err)
+	mergeHash, err := worktree.Commit("merge other-parent into first-parent", &git.CommitOptions{
+		Author:  author,
+		Parents: []plumbing.Hash{firstParentHash, otherParentHash},
+	})
+	assert.NoError(t, err)
+
+	changesCh, err := logDiffTree(ctx, repo, LogOptions{From: mergeHash.String()})
+	assert.NoError(t, err)
+
+	var merge *CommitChanges
+	for cc := range changesCh {
+		cc := cc
+		if cc.CommitHash == mergeHash.String() {
+			merge = &cc
+		}
+	}
+
+	if assert.NotNil(t, merge) {
+		assert.True(t, merge.IsMerge)
+		assert.Equal(t, []string{"merge-result.txt"}, paths(merge.Files))
+	}
 }
 
+// testRenameDetection builds a throwaway in-memory repository, renames a
+// file between two commits, and asserts diffTree reports it as a single
+// Rename record instead of a Delete+Insert pair.
+func testRenameDetection(ctx context.Context, t *T) {
+	st := memory.NewStorage()
+	wt := memfs.New()
+
+	repo, err := git.Init(st, wt)
+	assert.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	content := []byte("line one\nline two\nline three\nline four\n")
+	f, err := wt.Create("old.txt")
+	assert.NoError(t, err)
+
+	_, err = f.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	_, err = worktree.Add("old.txt")
+	assert.NoError(t, err)
+
+	author := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+
+	_, err = worktree.Commit("add old.txt", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	assert.NoError(t, wt.Rename("old.txt", "new.txt"))
+
+	_, err = worktree.Add(".")
+	assert.NoError(t, err)
+
+	renameCommitHash, err := worktree.Commit("rename old.txt to new.txt", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	result, err := diffTree(ctx, repo, renameCommitHash.String(), DiffTreeOptions{RenameSimilarity: 50})
+	assert.NoError(t, err)
+	assert.Len(t, result.Files, 1)
+
+	if len(result.Files) == 1 {
+		assert.Equal(t, ActionRename, result.Files[0].Action)
+		assert.Equal(t, "old.txt", result.Files[0].OldPath)
+		assert.Equal(t, "new.txt", result.Files[0].NewPath)
+		assert.True(t, result.Files[0].Score >= 50)
+	}
+}
+
+// testCopyDetection builds a throwaway in-memory repository where a commit
+// adds a new file whose content is byte-for-byte identical to an existing,
+// untouched file, and asserts diffTree reports it as a Copy rather than a
+// plain Insert.
+func testCopyDetection(ctx context.Context, t *T) {
+	st := memory.NewStorage()
+	wt := memfs.New()
+
+	repo, err := git.Init(st, wt)
+	assert.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	author := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+
+	original, err := wt.Create("original.txt")
+	assert.NoError(t, err)
+	_, err = original.Write([]byte("line one\nline two\nline three\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, original.Close())
+
+	_, err = worktree.Add("original.txt")
+	assert.NoError(t, err)
+
+	_, err = worktree.Commit("add original.txt", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	copyFile, err := wt.Create("copy.txt")
+	assert.NoError(t, err)
+	_, err = copyFile.Write([]byte("line one\nline two\nline three\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, copyFile.Close())
+
+	_, err = worktree.Add("copy.txt")
+	assert.NoError(t, err)
+
+	copyCommitHash, err := worktree.Commit("add copy.txt", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	result, err := diffTree(ctx, repo, copyCommitHash.String(), DiffTreeOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, result.Files, 1)
+
+	if len(result.Files) == 1 {
+		assert.Equal(t, ActionCopy, result.Files[0].Action)
+		assert.Equal(t, "original.txt", result.Files[0].OldPath)
+		assert.Equal(t, "copy.txt", result.Files[0].NewPath)
+		assert.EqualValues(t, 100, result.Files[0].Score)
+	}
+}
+
+// testCopyDetectionIgnoresSimultaneousInserts builds a commit that adds two
+// brand-new, byte-identical files at once, with no prior unchanged file to
+// have been copied from. Neither has existed before, so both must be
+// reported as plain Inserts rather than mislabeled as copies of each other.
+func testCopyDetectionIgnoresSimultaneousInserts(ctx context.Context, t *T) {
+	st := memory.NewStorage()
+	wt := memfs.New()
+
+	repo, err := git.Init(st, wt)
+	assert.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	author := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+
+	write := func(path, content string) {
+		f, err := wt.Create(path)
+		assert.NoError(t, err)
+
+		_, err = f.Write([]byte(content))
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	write("unrelated.txt", "nothing to do with the pair below\n")
+	_, err = worktree.Add("unrelated.txt")
+	assert.NoError(t, err)
+	_, err = worktree.Commit("add unrelated.txt", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	write("a.txt", "identical content\n")
+	write("b.txt", "identical content\n")
+	_, err = worktree.Add("a.txt")
+	assert.NoError(t, err)
+	_, err = worktree.Add("b.txt")
+	assert.NoError(t, err)
+
+	pairHash, err := worktree.Commit("add a.txt and b.txt", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	result, err := diffTree(ctx, repo, pairHash.String(), DiffTreeOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, result.Files, 2)
+
+	for _, file := range result.Files {
+		assert.Equal(t, ActionInsert, file.Action)
+	}
+}
+
+// testPatchHunkHeaderZeroCounts builds a throwaway in-memory repository with
+// a commit that adds a brand-new file and asserts the rendered patch's hunk
+// header uses start 0 on the old side ("@@ -0,0 +1,N @@"), matching what
+// real git and `git apply` expect for a zero-count side, instead of the
+// "-1,0" a naively incremented start would produce.
+func testPatchHunkHeaderZeroCounts(ctx context.Context, t *T) {
+	st := memory.NewStorage()
+	wt := memfs.New()
+
+	repo, err := git.Init(st, wt)
+	assert.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	author := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+
+	unrelated, err := wt.Create("unrelated.txt")
+	assert.NoError(t, err)
+	_, err = unrelated.Write([]byte("nothing to do with new.txt\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, unrelated.Close())
+
+	_, err = worktree.Add("unrelated.txt")
+	assert.NoError(t, err)
+	_, err = worktree.Commit("add unrelated.txt", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	f, err := wt.Create("new.txt")
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("line one\nline two\nline three\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	_, err = worktree.Add("new.txt")
+	assert.NoError(t, err)
+
+	newHash, err := worktree.Commit("add new.txt", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	result, err := diffTree(ctx, repo, newHash.String(), DiffTreeOptions{Patch: true})
+	assert.NoError(t, err)
+	assert.Contains(t, result.Patch, "@@ -0,0 +1,3 @@")
+}
+
+// testCombinedDiff builds a throwaway in-memory repository with a merge
+// commit and asserts that diffTree refuses it by default (ErrMergeCommit)
+// but, with CombinedDiff set, reports only the path both parents disagree
+// with the merge result on, tagged with its per-parent markers.
+func testCombinedDiff(ctx context.Context, t *T) {
+	st := memory.NewStorage()
+	wt := memfs.New()
+
+	repo, err := git.Init(st, wt)
+	assert.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	author := &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()}
+
+	write := func(content string) {
+		f, err := wt.Create("shared.txt")
+		assert.NoError(t, err)
+
+		_, err = f.Write([]byte(content))
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	write("base\n")
+	_, err = worktree.Add("shared.txt")
+	assert.NoError(t, err)
+	baseHash, err := worktree.Commit("base", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	assert.NoError(t, worktree.Checkout(&git.CheckoutOptions{Hash: baseHash, Branch: plumbing.ReferenceName("refs/heads/a"), Create: true}))
+	write("from branch a\n")
+	_, err = worktree.Add("shared.txt")
+	assert.NoError(t, err)
+	aHash, err := worktree.Commit("change on a", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	assert.NoError(t, worktree.Checkout(&git.CheckoutOptions{Hash: baseHash, Branch: plumbing.ReferenceName("refs/heads/b"), Create: true}))
+	write("from branch b\n")
+	_, err = worktree.Add("shared.txt")
+	assert.NoError(t, err)
+	bHash, err := worktree.Commit("change on b", &git.CommitOptions{Author: author})
+	assert.NoError(t, err)
+
+	assert.NoError(t, worktree.Checkout(&git.CheckoutOptions{Hash: aHash}))
+	write("merged\n")
+	_, err = worktree.Add("shared.txt")
+	assert.NoError(t, err)
+	mergeHash, err := worktree.Commit("merge b into a", &git.CommitOptions{
+		Author:  author,
+		Parents: []plumbing.Hash{aHash, bHash},
+	})
+	assert.NoError(t, err)
+
+	_, err = diffTree(ctx, repo, mergeHash.String(), DiffTreeOptions{})
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrMergeCommit)
+
+	result, err := diffTree(ctx, repo, mergeHash.String(), DiffTreeOptions{CombinedDiff: true})
+	assert.NoError(t, err)
+	assert.Len(t, result.Files, 1)
+
+	if len(result.Files) == 1 {
+		assert.Equal(t, "shared.txt", result.Files[0].NewPath)
+		assert.Equal(t, "MM", result.Files[0].Markers)
+	}
+}
+
+// renderPatch renders a unified diff of patch, trimming each hunk's
+// surrounding context down to contextLines, the same way `git diff-tree -p`
+// (or `-U<n>`) does. It delegates to go-git's own diff.UnifiedEncoder (the
+// same encoder *object.Patch.String()/Encode use internally, just with the
+// hardcoded diff.DefaultContextLines swapped for contextLines) rather than
+// hand-rolling hunk windowing.
+func renderPatch(patch *object.Patch, contextLines int) (string, error) {
+	var b strings.Builder
+
+	if err := diff.NewUnifiedEncoder(&b, contextLines).Encode(patch); err != nil {
+		return "", fmt.Errorf("error encoding patch: %w", err)
+	}
+
+	return b.String(), nil
+}
+
+// renderStat renders a `--stat` style summary: one line per changed file
+// plus a final "N file(s) changed, X insertion(s)(+), Y deletion(s)(-)" line,
+// aggregated from each FilePatch's Chunks.
+func renderStat(patch *object.Patch) string {
+	var b strings.Builder
+
+	filePatches := patch.FilePatches()
+	totalIns, totalDel := 0, 0
+
+	for _, fp := range filePatches {
+		from, to := fp.Files()
+		name := diffFilePath(to)
+		if name == "" {
+			name = diffFilePath(from)
+		}
+
+		if fp.IsBinary() {
+			fmt.Fprintf(&b, " %s | Bin\n", name)
+			continue
+		}
+
+		ins, del := 0, 0
+		for _, chunk := range fp.Chunks() {
+			lines := countLines(chunk.Content())
+			switch chunk.Type() {
+			case diff.Add:
+				ins += lines
+			case diff.Delete:
+				del += lines
+			}
+		}
+
+		totalIns += ins
+		totalDel += del
+		fmt.Fprintf(&b, " %s | %d %s\n", name, ins+del, statBar(ins, del))
+	}
+
+	fmt.Fprintf(&b, " %d file(s) changed, %d insertion(s)(+), %d deletion(s)(-)\n", len(filePatches), totalIns, totalDel)
+
+	return b.String()
+}
+
+// statBar renders the +/- bar git prints next to each --stat line, capped so
+// a single huge file doesn't blow up the output width.
+func statBar(ins, del int) string {
+	const maxWidth = 20
+	total := ins + del
+	if total == 0 {
+		return ""
+	}
+
+	plus := ins
+	minus := del
+	if total > maxWidth {
+		plus = ins * maxWidth / total
+		minus = maxWidth - plus
+	}
+
+	return strings.Repeat("+", plus) + strings.Repeat("-", minus)
+}
+
+// countLines counts the lines in a chunk of patch content, empty ones
+// included; a trailing newline does not start an extra line.
+func countLines(content string) int {
+	if content == "" {
+		return 0
+	}
+
+	lines := strings.Split(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return len(lines)
+}
+
+// diffFilePath returns f.Path(), or "" for a nil diff.File (e.g. the "from"
+// side of an Insert, or the "to" side of a Delete).
+func diffFilePath(f diff.File) string {
+	if f == nil {
+		return ""
+	}
+
+	return f.Path()
+}
+
+
 // T mimics testing.T object to be used by assertion library.
 type T struct{}
 